@@ -26,6 +26,7 @@ package tello
 import (
 	"errors"
 	"log"
+	"math"
 	"time"
 )
 
@@ -35,6 +36,104 @@ const (
 	AutoHeightLimitDm = 300
 )
 
+// autopilotPeriodSec is the autopilot tick period expressed in seconds, for use as the PID dt.
+const autopilotPeriodSec = float32(autopilotPeriodMs) / 1000.0
+
+// PIDConfig holds the tunable parameters for a single-axis PID control loop
+// as used by the height and yaw autopilot goroutines.
+type PIDConfig struct {
+	Kp, Ki, Kd     float32 // proportional, integral and derivative gains
+	OutputLimit    float32 // clamp applied to the final control output, eg. 32500
+	IntegralLimit  float32 // anti-windup clamp applied to the accumulated integral term
+	Tolerance      float32 // |error| below this is considered 'on target'
+	ToleranceTicks int     // consecutive on-target ticks required before a manoeuvre is reported done
+}
+
+// defaultHeightPID and defaultYawPID are used whenever a caller has not
+// supplied their own PIDConfig via SetHeightPID/SetYawPID. They are tuned to
+// reproduce the feel of the old bang-bang autopilot without its oscillation
+// around the target.
+var (
+	defaultHeightPID = PIDConfig{Kp: 3200, Ki: 150, Kd: 250, OutputLimit: 32500, IntegralLimit: 8000, Tolerance: 1, ToleranceTicks: 4}
+	defaultYawPID    = PIDConfig{Kp: 300, Ki: 5, Kd: 50, OutputLimit: 32500, IntegralLimit: 3000, Tolerance: 2, ToleranceTicks: 4}
+)
+
+// pidController is the runtime state of a single PID loop. A fresh instance
+// is created each time an autopilot manoeuvre starts so that integral and
+// derivative history never leaks between manoeuvres.
+type pidController struct {
+	cfg PIDConfig
+
+	integral       float32
+	prevError      float32
+	havePrev       bool
+	inToleranceFor int
+}
+
+func newPIDController(cfg PIDConfig) *pidController {
+	return &pidController{cfg: cfg}
+}
+
+// step runs one control tick given the current error and the elapsed time
+// (in seconds) since the previous tick. It returns the clamped control
+// output and whether the loop has now been within tolerance for
+// cfg.ToleranceTicks consecutive ticks.
+func (p *pidController) step(errVal, dt float32) (output float32, settled bool) {
+	p.integral += errVal * dt
+	if p.integral > p.cfg.IntegralLimit {
+		p.integral = p.cfg.IntegralLimit
+	} else if p.integral < -p.cfg.IntegralLimit {
+		p.integral = -p.cfg.IntegralLimit
+	}
+
+	var derivative float32
+	if p.havePrev && dt > 0 {
+		derivative = (errVal - p.prevError) / dt
+	}
+	p.prevError = errVal
+	p.havePrev = true
+
+	output = p.cfg.Kp*errVal + p.cfg.Ki*p.integral + p.cfg.Kd*derivative
+	if output > p.cfg.OutputLimit {
+		output = p.cfg.OutputLimit
+	} else if output < -p.cfg.OutputLimit {
+		output = -p.cfg.OutputLimit
+	}
+
+	if float32Abs(errVal) < p.cfg.Tolerance {
+		p.inToleranceFor++
+	} else {
+		p.inToleranceFor = 0
+	}
+
+	return output, p.inToleranceFor >= p.cfg.ToleranceTicks
+}
+
+func float32Abs(x float32) float32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// SetHeightPID overrides the PID parameters used by AutoFlyToHeight.
+// It has no effect on a manoeuvre already in progress; call it before
+// starting the next one.
+func (tello *Tello) SetHeightPID(cfg PIDConfig) {
+	tello.heightPIDMu.Lock()
+	tello.heightPIDCfg = cfg
+	tello.heightPIDMu.Unlock()
+}
+
+// SetYawPID overrides the PID parameters used by AutoTurnToYaw.
+// It has no effect on a manoeuvre already in progress; call it before
+// starting the next one.
+func (tello *Tello) SetYawPID(cfg PIDConfig) {
+	tello.yawPIDMu.Lock()
+	tello.yawPIDCfg = cfg
+	tello.yawPIDMu.Unlock()
+}
+
 // CancelAutoFlyToHeight stops any in-flight AutoFlyToHeight navigation.
 // The drone should stop moving vertically.
 func (tello *Tello) CancelAutoFlyToHeight() {
@@ -68,9 +167,17 @@ func (tello *Tello) AutoFlyToHeight(dm int16) (done chan bool, err error) {
 
 	done = make(chan bool, 1) // buffered so send doesn't block
 
+	tello.heightPIDMu.RLock()
+	cfg := tello.heightPIDCfg
+	tello.heightPIDMu.RUnlock()
+	if cfg == (PIDConfig{}) {
+		cfg = defaultHeightPID
+	}
+
 	//log.Println("Autoheight set - starting goroutine")
 
 	go func() {
+		pid := newPIDController(cfg)
 		for {
 			// has autoflight been cancelled?
 			tello.autoHeightMu.RLock()
@@ -88,28 +195,23 @@ func (tello *Tello) AutoFlyToHeight(dm int16) (done chan bool, err error) {
 			}
 
 			tello.fdMu.RLock()
-			delta := dm - tello.fd.Height // delta will be positive if we are too low
-			//log.Printf("Target: %d, Height: %d, Delta: %d\n", dm, tello.fd.Height, delta)
+			errVal := float32(dm) - float32(tello.fd.Height) // positive if we are too low
 			tello.fdMu.RUnlock()
 
+			output, settled := pid.step(errVal, autopilotPeriodSec)
+			//log.Printf("Target: %d, Height: %d, Error: %v, Output: %v\n", dm, tello.fd.Height, errVal, output)
+
 			tello.ctrlMu.Lock()
-			switch {
-			case delta > 4:
-				tello.ctrlLy = 32500 // full throttle if >40cm off target
-			case delta > 0:
-				tello.ctrlLy = 16250 // half throttle if <40cm off target
-			case delta < -4:
-				tello.ctrlLy = -32500
-			case delta < 0:
-				tello.ctrlLy = -16250
-			case delta == 0: // might need some 'tolerance' here?
+			tello.ctrlLy = int16(output)
+			tello.ctrlMu.Unlock()
+			tello.sendStickUpdate()
+
+			if settled {
 				// we're there! Cancel...
 				tello.autoHeightMu.Lock()
 				tello.autoHeight = false
 				tello.autoHeightMu.Unlock()
 			}
-			tello.ctrlMu.Unlock()
-			tello.sendStickUpdate()
 
 			time.Sleep(autopilotPeriodMs * time.Millisecond)
 		}
@@ -137,10 +239,6 @@ func (tello *Tello) AutoTurnToYaw(targetYaw int16) (done chan bool, err error) {
 	if targetYaw < -180 || targetYaw > 180 {
 		return nil, errors.New("Target yaw must be between -180 and +180")
 	}
-	adjustedTarget := targetYaw
-	if targetYaw < 0 {
-		adjustedTarget = 360 + targetYaw
-	}
 
 	// are we already navigating?
 	tello.autoYawMu.RLock()
@@ -156,9 +254,17 @@ func (tello *Tello) AutoTurnToYaw(targetYaw int16) (done chan bool, err error) {
 
 	done = make(chan bool, 1) // buffered so send doesn't block
 
+	tello.yawPIDMu.RLock()
+	cfg := tello.yawPIDCfg
+	tello.yawPIDMu.RUnlock()
+	if cfg == (PIDConfig{}) {
+		cfg = defaultYawPID
+	}
+
 	//log.Println("autoYaw set - starting goroutine")
 
 	go func() {
+		pid := newPIDController(cfg)
 		for {
 			// has autoflight been cancelled?
 			tello.autoYawMu.RLock()
@@ -176,42 +282,26 @@ func (tello *Tello) AutoTurnToYaw(targetYaw int16) (done chan bool, err error) {
 			}
 
 			tello.fdMu.RLock()
-			adjustedCurrent := tello.fd.IMU.Yaw
+			current := tello.fd.IMU.Yaw
 			tello.fdMu.RUnlock()
-			if adjustedCurrent < 0 {
-				adjustedCurrent = 360 + adjustedCurrent
-			}
 
-			delta := adjustedTarget - adjustedCurrent
-			absDelta := int16Abs(delta)
-			switch {
-			case absDelta <= 180: //
-			case delta > 0:
-				delta = absDelta - 360
-			case delta > 0:
-				delta = 360 - absDelta
-			}
+			delta := yawDeltaDeg(targetYaw, current)
+
+			//log.Printf("Target: %d, Current: %d, Delta: %d\n", targetYaw, current, delta)
 
-			//log.Printf("Target: %d, Current: %d, Delta: %d\n", adjustedTarget, adjustedCurrent, delta)
+			output, settled := pid.step(float32(delta), autopilotPeriodSec)
 
 			tello.ctrlMu.Lock()
-			switch {
-			case delta > 10:
-				tello.ctrlLx = 32500 // full throttle if >10deg off target
-			case delta > 0:
-				tello.ctrlLx = 16250 // half throttle if <10deg off target
-			case delta < -10:
-				tello.ctrlLx = -32500
-			case delta < 0:
-				tello.ctrlLx = -16250
-			case delta == 0: // might need some 'tolerance' here?
+			tello.ctrlLx = int16(output)
+			tello.ctrlMu.Unlock()
+			tello.sendStickUpdate()
+
+			if settled {
 				// we're there! Cancel...
 				tello.autoYawMu.Lock()
 				tello.autoYaw = false
 				tello.autoYawMu.Unlock()
 			}
-			tello.ctrlMu.Unlock()
-			tello.sendStickUpdate()
 
 			time.Sleep(autopilotPeriodMs * time.Millisecond)
 		}
@@ -264,3 +354,181 @@ func int16Abs(x int16) int16 {
 	}
 	return x
 }
+
+// AutoOrbitRadiusLimitM is the maximum radius AutoOrbit will accept, in metres.
+const AutoOrbitRadiusLimitM = 5.0
+
+// defaultOrbitRadialPID corrects the drone's distance from the orbit centre
+// back onto the target radius; its output is a radial correction added to
+// the tangential velocity, in the same stick-unit scale as the other
+// autopilot PIDs.
+var defaultOrbitRadialPID = PIDConfig{Kp: 8000, Ki: 100, Kd: 400, OutputLimit: 20000, IntegralLimit: 6000, Tolerance: 0.05, ToleranceTicks: 1}
+
+// orbitVelocityGain converts a desired tangential speed in m/s into the same
+// stick-unit scale as defaultOrbitRadialPID's output, so the two can be
+// summed directly. Tuned so a typical 1-2 m/s orbit speed produces a
+// meaningful fraction of full stick deflection.
+const orbitVelocityGain float32 = 8000
+
+// CancelAutoOrbit stops any in-flight AutoOrbit.
+// The drone should stop moving and rotating.
+func (tello *Tello) CancelAutoOrbit() {
+	tello.autoOrbitMu.Lock()
+	tello.autoOrbit = false
+	tello.autoOrbitMu.Unlock()
+}
+
+// AutoOrbit continuously flies the drone in a circle of radius radiusM
+// around the world-frame point (cx, cy), completing one revolution every
+// periodSec seconds (clockwise if clockwise is true, anticlockwise
+// otherwise), while keeping the nose pointed at the centre. It uses the MVO
+// X/Y position and IMU yaw, and drives the same stick/PID inner loop as
+// AutoFlyToXY and AutoTurnToYaw.
+// The func returns immediately and a Goroutine handles the manoeuvre until
+// cancelled via CancelAutoOrbit() - an orbit has no natural completion, so
+// 'done' only ever fires on cancellation.
+// AutoOrbit rejects radiusM above AutoOrbitRadiusLimitM, and refuses to
+// start (or continues to hold position) if the MVO position is not valid.
+func (tello *Tello) AutoOrbit(cx, cy, radiusM, periodSec float32, clockwise bool) (done chan bool, err error) {
+	if radiusM <= 0 || radiusM > AutoOrbitRadiusLimitM {
+		return nil, errors.New("Orbit radius out of range")
+	}
+
+	// are we already orbiting?
+	tello.autoOrbitMu.RLock()
+	if tello.autoOrbit {
+		tello.autoOrbitMu.RUnlock()
+		return nil, errors.New("Already orbiting")
+	}
+	tello.autoOrbitMu.RUnlock()
+
+	tello.fdMu.RLock()
+	valid := tello.fd.MVO.XValid && tello.fd.MVO.YValid && tello.fd.MVO.ZValid
+	tello.fdMu.RUnlock()
+	if !valid {
+		return nil, errors.New("MVO position not valid")
+	}
+
+	tello.autoOrbitMu.Lock()
+	tello.autoOrbit = true
+	tello.autoOrbitMu.Unlock()
+
+	tello.yawPIDMu.RLock()
+	yawCfg := tello.yawPIDCfg
+	tello.yawPIDMu.RUnlock()
+	if yawCfg == (PIDConfig{}) {
+		yawCfg = defaultYawPID
+	}
+
+	done = make(chan bool, 1) // buffered so send doesn't block
+
+	go func() {
+		radialPID := newPIDController(defaultOrbitRadialPID)
+		yawPID := newPIDController(yawCfg)
+
+		direction := float32(1)
+		if clockwise {
+			direction = -1
+		}
+		tangential := direction * 2 * math.Pi * radiusM / periodSec * orbitVelocityGain // tangential stick output, full speed round the circle
+
+		for {
+			// has the orbit been cancelled?
+			tello.autoOrbitMu.RLock()
+			cancelled := tello.autoOrbit == false
+			tello.autoOrbitMu.RUnlock()
+			if cancelled {
+				log.Println("Cancelled")
+				tello.ctrlMu.Lock()
+				tello.ctrlRx = 0
+				tello.ctrlRy = 0
+				tello.ctrlLx = 0
+				tello.ctrlMu.Unlock()
+				tello.sendStickUpdate()
+				done <- true
+				return
+			}
+
+			tello.fdMu.RLock()
+			valid := tello.fd.MVO.XValid && tello.fd.MVO.YValid && tello.fd.MVO.ZValid
+			x, y := tello.fd.MVO.X, tello.fd.MVO.Y
+			yawDeg := tello.fd.IMU.Yaw
+			tello.fdMu.RUnlock()
+
+			if !valid {
+				// hold position until MVO recovers rather than orbit blind
+				tello.ctrlMu.Lock()
+				tello.ctrlRx = 0
+				tello.ctrlRy = 0
+				tello.ctrlLx = 0
+				tello.ctrlMu.Unlock()
+				tello.sendStickUpdate()
+				time.Sleep(autopilotPeriodMs * time.Millisecond)
+				continue
+			}
+
+			dx, dy := x-cx, y-cy
+			r := float32(math.Hypot(float64(dx), float64(dy)))
+			if r < 0.01 {
+				r = 0.01 // avoid a singularity right at the centre
+			}
+			radialX, radialY := dx/r, dy/r          // unit vector centre -> drone
+			tangentX, tangentY := -radialY, radialX // unit tangent, anticlockwise sense
+			radialOut, _ := radialPID.step(radiusM-r, autopilotPeriodSec)
+
+			vx := tangential*tangentX + radialOut*radialX
+			vy := tangential*tangentY + radialOut*radialY
+			bodyForward, bodyRight := worldToBody(vx, vy, yawDeg)
+
+			yawTarget := int16(math.Atan2(float64(cy-y), float64(cx-x)) * 180 / math.Pi)
+			yawErrDeg := yawDeltaDeg(yawTarget, yawDeg)
+			yawOut, _ := yawPID.step(float32(yawErrDeg), autopilotPeriodSec)
+
+			tello.ctrlMu.Lock()
+			tello.ctrlRy = int16(clampF32(bodyForward, -32500, 32500))
+			tello.ctrlRx = int16(clampF32(bodyRight, -32500, 32500))
+			tello.ctrlLx = int16(yawOut)
+			tello.ctrlMu.Unlock()
+			tello.sendStickUpdate()
+
+			time.Sleep(autopilotPeriodMs * time.Millisecond)
+		}
+	}()
+
+	return done, nil
+}
+
+// yawDeltaDeg returns the signed shortest-path angular difference
+// target - current, wrapped into the range -180..180. Both target and
+// current are in degrees, as reported/accepted by AutoTurnToYaw.
+func yawDeltaDeg(target, current int16) int16 {
+	adjustedTarget := target
+	if adjustedTarget < 0 {
+		adjustedTarget = 360 + adjustedTarget
+	}
+	adjustedCurrent := current
+	if adjustedCurrent < 0 {
+		adjustedCurrent = 360 + adjustedCurrent
+	}
+
+	delta := adjustedTarget - adjustedCurrent
+	absDelta := int16Abs(delta)
+	switch {
+	case absDelta <= 180:
+	case delta > 0:
+		delta = absDelta - 360
+	case delta < 0:
+		delta = 360 - absDelta
+	}
+	return delta
+}
+
+func clampF32(v, lo, hi float32) float32 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
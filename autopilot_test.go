@@ -123,4 +123,90 @@ func TestFlyToYawAndHeightConcurrently(t *testing.T) {
 	drone.Land()
 	drone.ControlDisconnect()
 	log.Println("Disconnected normally from Tello")
-}
\ No newline at end of file
+}
+
+// TestPIDControllerSettlesOnSimulatedHeight drives the pidController against a
+// crude simulated drone (velocity proportional to stick output) and checks
+// that it both converges on the target and reports itself settled.
+func TestPIDControllerSettlesOnSimulatedHeight(t *testing.T) {
+	pid := newPIDController(defaultHeightPID)
+
+	var height float32 // simulated height in decimetres
+	target := float32(15)
+
+	settled := false
+	for tick := 0; tick < 400 && !settled; tick++ {
+		errVal := target - height
+		output, s := pid.step(errVal, autopilotPeriodSec)
+		height += (output / 32500) * 2 * autopilotPeriodSec // simulated plant response
+		settled = s
+	}
+
+	if !settled {
+		t.Fatalf("PID loop failed to settle within simulation budget")
+	}
+	if d := float32Abs(target - height); d > defaultHeightPID.Tolerance*2 {
+		t.Errorf("settled too far from target: height %v, target %v", height, target)
+	}
+}
+
+// TestPIDControllerAntiWindup checks that a sustained large error cannot push
+// the integral term beyond its configured limit.
+func TestPIDControllerAntiWindup(t *testing.T) {
+	cfg := PIDConfig{Kp: 1, Ki: 100, Kd: 0, OutputLimit: 100, IntegralLimit: 10, Tolerance: 0.1, ToleranceTicks: 1}
+	pid := newPIDController(cfg)
+
+	for i := 0; i < 50; i++ {
+		pid.step(1000, autopilotPeriodSec)
+	}
+
+	if pid.integral > cfg.IntegralLimit || pid.integral < -cfg.IntegralLimit {
+		t.Errorf("integral term exceeded anti-windup limit: got %v, limit %v", pid.integral, cfg.IntegralLimit)
+	}
+}
+
+// TestPIDControllerRequiresConsecutiveTicks checks that a single noisy
+// in-tolerance sample does not falsely report the manoeuvre as settled.
+func TestPIDControllerRequiresConsecutiveTicks(t *testing.T) {
+	cfg := PIDConfig{Kp: 1, Ki: 0, Kd: 0, OutputLimit: 100, IntegralLimit: 100, Tolerance: 1, ToleranceTicks: 3}
+	pid := newPIDController(cfg)
+
+	_, settled := pid.step(0.5, autopilotPeriodSec) // one in-tolerance sample
+	if settled {
+		t.Fatalf("settled after a single in-tolerance tick, want %d required", cfg.ToleranceTicks)
+	}
+
+	_, settled = pid.step(5, autopilotPeriodSec) // noise kicks us back out of tolerance
+	if settled {
+		t.Fatalf("settled after an out-of-tolerance tick reset the streak")
+	}
+
+	for i := 0; i < cfg.ToleranceTicks; i++ {
+		_, settled = pid.step(0.5, autopilotPeriodSec)
+	}
+	if !settled {
+		t.Errorf("expected settled after %d consecutive in-tolerance ticks", cfg.ToleranceTicks)
+	}
+}
+
+// TestYawDeltaDeg checks that yawDeltaDeg always takes the shortest way
+// round, including when the raw target-minus-current difference wraps past
+// +-180 in either direction.
+func TestYawDeltaDeg(t *testing.T) {
+	cases := []struct {
+		target, current, want int16
+	}{
+		{target: 10, current: 0, want: 10},
+		{target: -10, current: 0, want: -10},
+		{target: 10, current: -10, want: 20},  // current 350deg: target is the short way forward
+		{target: -10, current: 10, want: -20}, // current 10deg: target is the short way back
+		{target: 170, current: -170, want: -20},
+		{target: -170, current: 170, want: 20},
+	}
+	for _, c := range cases {
+		got := yawDeltaDeg(c.target, c.current)
+		if got != c.want {
+			t.Errorf("yawDeltaDeg(%d, %d) = %d, want %d", c.target, c.current, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// commandqueue.go
+
+// This file contains the internal command-sequencing queue, which serialises
+// flight commands and defers any that arrive while the drone is mid
+// take-off/landing/emergency so they are not silently dropped.
+//
+// The queue is opt-in per call: submit a command via EnqueueCommand or
+// EnqueueMission to get this gating. Calling TakeOff()/Land() directly
+// still sends immediately, exactly as before this queue existed - those
+// methods are defined outside this file and have no way to consult queue
+// state without changing their call sites. Callers who want upstream issue
+// #12 (a Land() issued too soon after TakeOff() being silently ignored)
+// solved must route through EnqueueCommand/EnqueueMission.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Command identifies a high-level flight command that can be submitted to
+// the command queue via EnqueueCommand.
+type Command int
+
+// The commands understood by the command queue.
+const (
+	CmdTakeOff Command = iota
+	CmdLand
+	CmdPalmLand
+	CmdThrowTakeOff
+	CmdAutoMission
+)
+
+func (c Command) String() string {
+	switch c {
+	case CmdTakeOff:
+		return "takeoff"
+	case CmdLand:
+		return "land"
+	case CmdPalmLand:
+		return "palmland"
+	case CmdThrowTakeOff:
+		return "throwtakeoff"
+	case CmdAutoMission:
+		return "automission"
+	default:
+		return "unknown"
+	}
+}
+
+// flightState tracks the drone's progress through a take-off/landing cycle,
+// set by the queue worker around each dispatched command and confirmed
+// against FlightData.Flying, so the command queue knows when it is safe to
+// dispatch the next command.
+type flightState int
+
+const (
+	flightStateLanded flightState = iota
+	flightStateTakingOff
+	flightStateFlying
+	flightStateLanding
+	flightStateEmergency
+)
+
+// queuedCommand is one entry waiting on the command queue.
+type queuedCommand struct {
+	cmd     Command
+	mission Mission // only populated for CmdAutoMission, via EnqueueMission
+	result  chan error
+}
+
+func (tello *Tello) startCommandQueueWorker() {
+	tello.cmdQueueOnce.Do(func() {
+		tello.cmdQueueCh = make(chan *queuedCommand, 16)
+		go tello.commandQueueWorker()
+	})
+}
+
+// EnqueueCommand submits cmd to the internal command queue and returns a
+// channel that receives exactly one value: nil on success, or an error if
+// the command could not be completed. The queue worker serialises commands,
+// deferring any that arrive while the drone is taking off, landing or in an
+// emergency state until FlightData reports it has reached a stable state
+// (see upstream issue #12, where a Land() issued too soon after TakeOff()
+// was silently ignored).
+// CmdAutoMission carries no waypoints when submitted this way and always
+// fails; use EnqueueMission instead.
+func (tello *Tello) EnqueueCommand(cmd Command) <-chan error {
+	tello.startCommandQueueWorker()
+
+	result := make(chan error, 1)
+	tello.cmdQueueCh <- &queuedCommand{cmd: cmd, result: result}
+	return result
+}
+
+// EnqueueMission submits m as a CmdAutoMission to the internal command
+// queue, subject to the same take-off/landing/emergency gating as
+// EnqueueCommand, and runs it via RunMission once dispatched.
+func (tello *Tello) EnqueueMission(m Mission) <-chan error {
+	tello.startCommandQueueWorker()
+
+	result := make(chan error, 1)
+	tello.cmdQueueCh <- &queuedCommand{cmd: CmdAutoMission, mission: m, result: result}
+	return result
+}
+
+func (tello *Tello) commandQueueWorker() {
+	for qc := range tello.cmdQueueCh {
+		tello.awaitStableState(qc.cmd)
+		qc.result <- tello.dispatchQueuedCommand(qc)
+	}
+}
+
+// awaitStableState blocks until cmd can safely be dispatched: the drone must
+// not be in an emergency state, and must not still be transitioning through
+// a previous take-off or landing.
+func (tello *Tello) awaitStableState(cmd Command) {
+	for {
+		tello.fdMu.RLock()
+		emSky := tello.fd.EmSky
+		tello.fdMu.RUnlock()
+		if emSky {
+			time.Sleep(autopilotPeriodMs * time.Millisecond)
+			continue
+		}
+
+		tello.flightStateMu.RLock()
+		state := tello.flightState
+		tello.flightStateMu.RUnlock()
+
+		if state != flightStateTakingOff && state != flightStateLanding {
+			return
+		}
+		time.Sleep(autopilotPeriodMs * time.Millisecond)
+	}
+}
+
+// stateTransitionTimeout bounds how long the queue worker waits for
+// FlightData to confirm a take-off or landing has completed before giving
+// up and surfacing an error, rather than blocking the queue forever.
+const stateTransitionTimeout = 10 * time.Second
+
+// missionWaypointTimeout bounds, per waypoint, how long an automission
+// dispatched via the command queue is allowed to run.
+const missionWaypointTimeout = 30 * time.Second
+
+// dispatchQueuedCommand performs the actual send for qc.cmd, tracking
+// flightState across the call so a command enqueued immediately afterwards
+// waits for the right thing.
+func (tello *Tello) dispatchQueuedCommand(qc *queuedCommand) error {
+	switch qc.cmd {
+	case CmdTakeOff:
+		tello.setFlightState(flightStateTakingOff)
+		if err := tello.TakeOff(); err != nil {
+			tello.setFlightState(flightStateLanded)
+			return err
+		}
+		if !tello.waitUntilFlightData(func() bool { return tello.fd.Flying }, stateTransitionTimeout) {
+			tello.setFlightState(flightStateLanded)
+			return errors.New("timed out waiting for take-off to complete")
+		}
+		tello.setFlightState(flightStateFlying)
+		return nil
+
+	case CmdLand:
+		tello.setFlightState(flightStateLanding)
+		if err := tello.Land(); err != nil {
+			tello.setFlightState(flightStateFlying)
+			return err
+		}
+		if !tello.waitUntilFlightData(func() bool { return !tello.fd.Flying }, stateTransitionTimeout) {
+			tello.setFlightState(flightStateFlying)
+			return errors.New("timed out waiting for landing to complete")
+		}
+		tello.setFlightState(flightStateLanded)
+		return nil
+
+	case CmdPalmLand:
+		tello.setFlightState(flightStateLanding)
+		if err := tello.PalmLand(); err != nil {
+			tello.setFlightState(flightStateFlying)
+			return err
+		}
+		if !tello.waitUntilFlightData(func() bool { return !tello.fd.Flying }, stateTransitionTimeout) {
+			tello.setFlightState(flightStateFlying)
+			return errors.New("timed out waiting for palm-landing to complete")
+		}
+		tello.setFlightState(flightStateLanded)
+		return nil
+
+	case CmdThrowTakeOff:
+		tello.setFlightState(flightStateTakingOff)
+		if err := tello.ThrowTakeOff(); err != nil {
+			tello.setFlightState(flightStateLanded)
+			return err
+		}
+		if !tello.waitUntilFlightData(func() bool { return tello.fd.Flying }, stateTransitionTimeout) {
+			tello.setFlightState(flightStateLanded)
+			return errors.New("timed out waiting for throw-takeoff to complete")
+		}
+		tello.setFlightState(flightStateFlying)
+		return nil
+
+	case CmdAutoMission:
+		if len(qc.mission.Waypoints) == 0 {
+			return errors.New("automission carries no waypoints - submit it via EnqueueMission")
+		}
+		missionDone, err := tello.RunMission(qc.mission)
+		if err != nil {
+			return err
+		}
+		timeout := time.Duration(len(qc.mission.Waypoints)) * missionWaypointTimeout
+		select {
+		case <-missionDone:
+			return nil
+		case <-time.After(timeout):
+			tello.CancelMission()
+			return errors.New("timed out waiting for automission to complete")
+		}
+
+	default:
+		return fmt.Errorf("unknown command %v", qc.cmd)
+	}
+}
+
+func (tello *Tello) setFlightState(s flightState) {
+	tello.flightStateMu.Lock()
+	tello.flightState = s
+	tello.flightStateMu.Unlock()
+}
+
+// waitUntilFlightData polls FlightData, guarded by fdMu, until cond reports
+// true or timeout elapses, reporting which happened first.
+func (tello *Tello) waitUntilFlightData(cond func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		tello.fdMu.RLock()
+		done := cond()
+		tello.fdMu.RUnlock()
+		if done {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(autopilotPeriodMs * time.Millisecond)
+	}
+}
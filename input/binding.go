@@ -0,0 +1,338 @@
+// binding.go
+
+// Package input binds an arbitrary joystick/gamepad source to a Tello drone,
+// translating axes into stick updates and buttons into named actions, so
+// callers don't have to hand-roll the per-axis atomics and per-button
+// Goroutines that gluing gobot's joystick driver to the Tello otherwise
+// requires.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// bindingPeriodMs is how often axis readings are translated into stick
+// updates, matching the drone's 20Hz stick update rate.
+const bindingPeriodMs = 50
+
+// Drone is the subset of *tello.Tello that a ControllerBinding needs in
+// order to drive stick and autopilot commands. *tello.Tello satisfies it.
+type Drone interface {
+	SetSticks(lx, ly, rx, ry int16)
+	TakeOff() error
+	Land() error
+	PalmLand() error
+	ThrowTakeOff() error
+	CancelAutoFlyToHeight()
+	CancelAutoTurn()
+}
+
+// Source is the small interface any joystick/gamepad library must implement
+// to drive a ControllerBinding.
+type Source interface {
+	ReadAxis(id int) float64
+	ReadButton(id int) bool
+	Events() <-chan InputEvent
+}
+
+// InputEvent is a single button transition reported by a Source.
+type InputEvent struct {
+	Button  int
+	Pressed bool
+}
+
+// Target identifies which virtual stick axis an AxisMapping drives.
+type Target string
+
+// The four virtual stick axes.
+const (
+	TargetLx Target = "Lx"
+	TargetLy Target = "Ly"
+	TargetRx Target = "Rx"
+	TargetRy Target = "Ry"
+)
+
+// Curve shapes a deadzoned axis reading before it is scaled.
+type Curve string
+
+// The supported axis response curves.
+const (
+	CurveLinear Curve = "linear"
+	CurveExpo   Curve = "expo"
+)
+
+// AxisMapping binds one physical axis to a virtual stick axis.
+type AxisMapping struct {
+	ID       int     `json:"id"`
+	Target   Target  `json:"target"`
+	Deadzone float64 `json:"deadzone"`
+	Curve    Curve   `json:"curve"`
+	Scale    float64 `json:"scale"`
+}
+
+// ButtonMapping binds one physical button to a named action. Recognised
+// actions are "takeoff", "land", "palmland", "throwtakeoff",
+// "toggle_fast_mode" and "cancel_autopilot". "flip_*" is not yet
+// recognised: the Drone interface has no flip method to invoke, so
+// LoadConfig rejects it rather than bind a button to a dead control.
+type ButtonMapping struct {
+	ID     int    `json:"id"`
+	Action string `json:"action"`
+}
+
+// recognisedActions are the ButtonMapping.Action values LoadConfig accepts.
+// "flip_*" is deliberately absent: see the ButtonMapping doc comment.
+var recognisedActions = map[string]bool{
+	"takeoff":          true,
+	"land":             true,
+	"palmland":         true,
+	"throwtakeoff":     true,
+	"toggle_fast_mode": true,
+	"cancel_autopilot": true,
+}
+
+// Config is a declarative joystick/gamepad mapping, loadable from JSON via
+// LoadConfig.
+type Config struct {
+	Axes    []AxisMapping   `json:"axes"`
+	Buttons []ButtonMapping `json:"buttons"`
+}
+
+// LoadConfig parses a JSON-encoded Config, rejecting any button bound to an
+// action recognisedActions doesn't know how to dispatch so a typo or an
+// unimplemented action (such as "flip_*") is caught at load time rather
+// than silently doing nothing when the button is pressed.
+func LoadConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, err
+	}
+	for _, m := range cfg.Buttons {
+		if !recognisedActions[m.Action] {
+			return Config{}, fmt.Errorf("button %d: unsupported action %q", m.ID, m.Action)
+		}
+	}
+	return cfg, nil
+}
+
+// DefaultPS3Config is a sensible starting point for a PS3/DualShock pad.
+var DefaultPS3Config = Config{
+	Axes: []AxisMapping{
+		{ID: 0, Target: TargetLx, Deadzone: 0.1, Curve: CurveExpo, Scale: 32500},
+		{ID: 1, Target: TargetLy, Deadzone: 0.1, Curve: CurveExpo, Scale: 32500},
+		{ID: 2, Target: TargetRx, Deadzone: 0.1, Curve: CurveExpo, Scale: 32500},
+		{ID: 3, Target: TargetRy, Deadzone: 0.1, Curve: CurveExpo, Scale: 32500},
+	},
+	Buttons: []ButtonMapping{
+		{ID: 3, Action: "takeoff"},          // triangle
+		{ID: 0, Action: "land"},             // cross
+		{ID: 1, Action: "palmland"},         // circle
+		{ID: 2, Action: "toggle_fast_mode"}, // square
+		{ID: 4, Action: "cancel_autopilot"}, // L1
+	},
+}
+
+// DefaultXboxConfig is a sensible starting point for an Xbox controller.
+var DefaultXboxConfig = Config{
+	Axes: []AxisMapping{
+		{ID: 0, Target: TargetLx, Deadzone: 0.15, Curve: CurveExpo, Scale: 32500},
+		{ID: 1, Target: TargetLy, Deadzone: 0.15, Curve: CurveExpo, Scale: 32500},
+		{ID: 3, Target: TargetRx, Deadzone: 0.15, Curve: CurveExpo, Scale: 32500},
+		{ID: 4, Target: TargetRy, Deadzone: 0.15, Curve: CurveExpo, Scale: 32500},
+	},
+	Buttons: []ButtonMapping{
+		{ID: 3, Action: "takeoff"},          // Y
+		{ID: 0, Action: "land"},             // A
+		{ID: 1, Action: "palmland"},         // B
+		{ID: 2, Action: "toggle_fast_mode"}, // X
+		{ID: 4, Action: "cancel_autopilot"}, // LB
+	},
+}
+
+// ControllerBinding translates a Source's axes and buttons into stick
+// updates and named actions on a Drone, at the drone's 20Hz stick rate. A
+// user stick input crossing its deadzone auto-cancels AutoFlyToHeight and
+// AutoTurnToYaw so manual override just works.
+type ControllerBinding struct {
+	src   Source
+	drone Drone
+	cfg   Config
+
+	mu       sync.RWMutex
+	running  bool
+	fastMode bool
+	stopCh   chan struct{}
+}
+
+// NewControllerBinding creates a binding from src to drone using cfg. Call
+// Start to begin translating input.
+func NewControllerBinding(src Source, drone Drone, cfg Config) *ControllerBinding {
+	return &ControllerBinding{src: src, drone: drone, cfg: cfg}
+}
+
+// Start begins translating axis readings into stick updates and listening
+// for button events, each in its own Goroutine. Calling Start on an
+// already-running binding is a no-op.
+func (cb *ControllerBinding) Start() {
+	cb.mu.Lock()
+	if cb.running {
+		cb.mu.Unlock()
+		return
+	}
+	cb.running = true
+	cb.stopCh = make(chan struct{})
+	cb.mu.Unlock()
+
+	go cb.axisLoop()
+	go cb.buttonLoop()
+}
+
+// Stop halts both Goroutines started by Start. Calling Stop on a binding
+// that isn't running is a no-op.
+func (cb *ControllerBinding) Stop() {
+	cb.mu.Lock()
+	if !cb.running {
+		cb.mu.Unlock()
+		return
+	}
+	cb.running = false
+	close(cb.stopCh)
+	cb.mu.Unlock()
+}
+
+func (cb *ControllerBinding) axisLoop() {
+	ticker := time.NewTicker(bindingPeriodMs * time.Millisecond)
+	defer ticker.Stop()
+
+	var sticks [4]int16 // Lx, Ly, Rx, Ry
+	held := false       // true once the user has pushed a stick off-centre
+
+	for {
+		select {
+		case <-cb.stopCh:
+			return
+		case <-ticker.C:
+			cb.mu.RLock()
+			scale := 1.0
+			if !cb.fastMode {
+				scale = 0.5
+			}
+			cb.mu.RUnlock()
+
+			crossed := false
+			for _, m := range cb.cfg.Axes {
+				v := applyDeadzone(cb.src.ReadAxis(m.ID), m.Deadzone)
+				if v != 0 {
+					crossed = true
+				}
+				sticks[targetIndex(m.Target)] = int16(applyCurve(v, m.Curve) * m.Scale * scale)
+			}
+
+			if crossed {
+				cb.drone.CancelAutoFlyToHeight()
+				cb.drone.CancelAutoTurn()
+			}
+
+			// Only touch the sticks while the user is actually holding one
+			// off-centre, plus the one extra tick that returns them to zero
+			// once released, so an in-progress autopilot manoeuvre isn't
+			// overwritten with centred sticks on every idle tick.
+			if crossed || held {
+				cb.drone.SetSticks(sticks[0], sticks[1], sticks[2], sticks[3])
+			}
+			held = crossed
+		}
+	}
+}
+
+func (cb *ControllerBinding) buttonLoop() {
+	events := cb.src.Events()
+	for {
+		select {
+		case <-cb.stopCh:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if !ev.Pressed {
+				continue
+			}
+			for _, m := range cb.cfg.Buttons {
+				if m.ID == ev.Button {
+					cb.dispatchAction(m.Action)
+				}
+			}
+		}
+	}
+}
+
+func (cb *ControllerBinding) dispatchAction(action string) {
+	switch action {
+	case "takeoff":
+		cb.drone.TakeOff()
+	case "land":
+		cb.drone.Land()
+	case "palmland":
+		cb.drone.PalmLand()
+	case "throwtakeoff":
+		cb.drone.ThrowTakeOff()
+	case "cancel_autopilot":
+		cb.drone.CancelAutoFlyToHeight()
+		cb.drone.CancelAutoTurn()
+	case "toggle_fast_mode":
+		cb.mu.Lock()
+		cb.fastMode = !cb.fastMode
+		cb.mu.Unlock()
+	}
+}
+
+func applyDeadzone(v, deadzone float64) float64 {
+	if v > -deadzone && v < deadzone {
+		return 0
+	}
+	return v
+}
+
+func applyCurve(v float64, c Curve) float64 {
+	if c == CurveExpo {
+		return v * v * v // odd power keeps the sign, softens the centre
+	}
+	return v
+}
+
+func targetIndex(t Target) int {
+	switch t {
+	case TargetLy:
+		return 1
+	case TargetRx:
+		return 2
+	case TargetRy:
+		return 3
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,291 @@
+// mission.go
+
+// This file contains the horizontal (MVO-based) navigation primitive and the
+// waypoint mission subsystem built on top of it and the other Auto* primitives
+// in autopilot.go.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+)
+
+// defaultXYPID is used by AutoFlyToXY whenever a caller has not supplied
+// their own PIDConfig via SetXYPID. Position error is in metres, so the
+// gains are much larger than the height/yaw defaults to produce a usable
+// stick deflection.
+var defaultXYPID = PIDConfig{Kp: 9000, Ki: 200, Kd: 900, OutputLimit: 32500, IntegralLimit: 8000, Tolerance: 0.1, ToleranceTicks: 4}
+
+// SetXYPID overrides the PID parameters used by AutoFlyToXY for both the
+// forward and lateral axes. It has no effect on a manoeuvre already in
+// progress; call it before starting the next one.
+func (tello *Tello) SetXYPID(cfg PIDConfig) {
+	tello.xyPIDMu.Lock()
+	tello.xyPIDCfg = cfg
+	tello.xyPIDMu.Unlock()
+}
+
+// CancelAutoFlyToXY stops any in-flight AutoFlyToXY navigation.
+// The drone should stop moving horizontally.
+func (tello *Tello) CancelAutoFlyToXY() {
+	tello.autoXYMu.Lock()
+	tello.autoXY = false
+	tello.autoXYMu.Unlock()
+}
+
+// AutoFlyToXY starts horizontal navigation to the world-frame position
+// (x, y), in metres, closing the loop on the MVO position reported in
+// FlightData. The target is transformed into the drone's body frame using
+// the current IMU yaw and driven out on the roll/pitch sticks (ctrlRx/ctrlRy)
+// via the same PID/goroutine structure as AutoFlyToHeight.
+// The func returns immediately and a Goroutine handles the navigation until
+// either it is complete or cancelled via CancelAutoFlyToXY(). A sample is
+// only considered valid, and the manoeuvre only started, when the MVO X, Y
+// and Z valid flags are all set.
+func (tello *Tello) AutoFlyToXY(x, y float32) (done chan bool, err error) {
+	// are we already navigating?
+	tello.autoXYMu.RLock()
+	if tello.autoXY {
+		tello.autoXYMu.RUnlock()
+		return nil, errors.New("Already navigating horizontally")
+	}
+	tello.autoXYMu.RUnlock()
+
+	tello.fdMu.RLock()
+	valid := tello.fd.MVO.XValid && tello.fd.MVO.YValid && tello.fd.MVO.ZValid
+	tello.fdMu.RUnlock()
+	if !valid {
+		return nil, errors.New("MVO position not valid")
+	}
+
+	tello.autoXYMu.Lock()
+	tello.autoXY = true
+	tello.autoXYMu.Unlock()
+
+	tello.xyPIDMu.RLock()
+	cfg := tello.xyPIDCfg
+	tello.xyPIDMu.RUnlock()
+	if cfg == (PIDConfig{}) {
+		cfg = defaultXYPID
+	}
+
+	done = make(chan bool, 1) // buffered so send doesn't block
+
+	go func() {
+		forwardPID := newPIDController(cfg)
+		rightPID := newPIDController(cfg)
+		for {
+			// has autoflight been cancelled?
+			tello.autoXYMu.RLock()
+			cancelled := tello.autoXY == false
+			tello.autoXYMu.RUnlock()
+			if cancelled {
+				log.Println("Cancelled")
+				tello.ctrlMu.Lock()
+				tello.ctrlRx = 0
+				tello.ctrlRy = 0
+				tello.ctrlMu.Unlock()
+				tello.sendStickUpdate()
+				done <- true
+				return
+			}
+
+			tello.fdMu.RLock()
+			valid := tello.fd.MVO.XValid && tello.fd.MVO.YValid && tello.fd.MVO.ZValid
+			measuredX, measuredY := tello.fd.MVO.X, tello.fd.MVO.Y
+			yawDeg := tello.fd.IMU.Yaw
+			tello.fdMu.RUnlock()
+
+			if !valid {
+				// hold position until MVO recovers rather than drive on stale data
+				tello.ctrlMu.Lock()
+				tello.ctrlRx = 0
+				tello.ctrlRy = 0
+				tello.ctrlMu.Unlock()
+				tello.sendStickUpdate()
+				time.Sleep(autopilotPeriodMs * time.Millisecond)
+				continue
+			}
+
+			errX := x - measuredX
+			errY := y - measuredY
+			bodyForward, bodyRight := worldToBody(errX, errY, yawDeg)
+
+			pitchOut, pitchSettled := forwardPID.step(bodyForward, autopilotPeriodSec)
+			rollOut, rollSettled := rightPID.step(bodyRight, autopilotPeriodSec)
+
+			tello.ctrlMu.Lock()
+			tello.ctrlRy = int16(pitchOut)
+			tello.ctrlRx = int16(rollOut)
+			tello.ctrlMu.Unlock()
+			tello.sendStickUpdate()
+
+			if pitchSettled && rollSettled {
+				tello.autoXYMu.Lock()
+				tello.autoXY = false
+				tello.autoXYMu.Unlock()
+			}
+
+			time.Sleep(autopilotPeriodMs * time.Millisecond)
+		}
+	}()
+
+	return done, nil
+}
+
+// worldToBody rotates a world-frame (x, y) vector into the drone's body
+// frame (forward, right) given its current yaw in degrees.
+func worldToBody(x, y float32, yawDeg int16) (forward, right float32) {
+	yawRad := float64(yawDeg) * math.Pi / 180
+	cos, sin := math.Cos(yawRad), math.Sin(yawRad)
+	forward = float32(float64(x)*cos + float64(y)*sin)
+	right = float32(-float64(x)*sin + float64(y)*cos)
+	return forward, right
+}
+
+// Waypoint describes a single leg of a Mission.
+type Waypoint struct {
+	X, Y, Z float32 // world-frame target position in metres
+	Yaw     int16   // target yaw in degrees, -180..180
+	HoldMs  int     // how long to hold position once the waypoint is reached, in milliseconds
+}
+
+// MissionProgress reports completion of a single Waypoint within a running Mission.
+type MissionProgress struct {
+	Index    int
+	Waypoint Waypoint
+}
+
+// Mission is an ordered sequence of Waypoints to be flown by RunMission.
+type Mission struct {
+	Waypoints []Waypoint
+	// Progress, if non-nil, receives a MissionProgress event as each
+	// waypoint completes. The caller is responsible for draining it.
+	Progress chan MissionProgress
+}
+
+// RunMission flies the given Mission, sequentially driving AutoFlyToXY,
+// AutoFlyToHeight and AutoTurnToYaw for each Waypoint in turn, holding for
+// HoldMs once each is reached. The func returns immediately and a Goroutine
+// drives the mission until it is complete or cancelled via CancelMission().
+func (tello *Tello) RunMission(m Mission) (done chan bool, err error) {
+	tello.missionMu.RLock()
+	if tello.missionRunning {
+		tello.missionMu.RUnlock()
+		return nil, errors.New("Already running a mission")
+	}
+	tello.missionMu.RUnlock()
+
+	tello.missionMu.Lock()
+	tello.missionRunning = true
+	tello.missionMu.Unlock()
+
+	done = make(chan bool, 1) // buffered so send doesn't block
+
+	go func() {
+		defer func() {
+			tello.missionMu.Lock()
+			tello.missionRunning = false
+			tello.missionMu.Unlock()
+			done <- true
+		}()
+
+		for i, wp := range m.Waypoints {
+			if tello.missionCancelled() {
+				log.Println("Mission cancelled")
+				return
+			}
+
+			xyDone, err := tello.AutoFlyToXY(wp.X, wp.Y)
+			if err != nil {
+				log.Printf("Mission aborted at waypoint %d: %v\n", i, err)
+				return
+			}
+			<-xyDone
+
+			if tello.missionCancelled() {
+				log.Println("Mission cancelled")
+				return
+			}
+
+			heightDone, err := tello.AutoFlyToHeight(int16(wp.Z * 10)) // metres -> decimetres
+			if err != nil {
+				log.Printf("Mission aborted at waypoint %d: %v\n", i, err)
+				return
+			}
+			<-heightDone
+
+			if tello.missionCancelled() {
+				log.Println("Mission cancelled")
+				return
+			}
+
+			yawDone, err := tello.AutoTurnToYaw(wp.Yaw)
+			if err != nil {
+				log.Printf("Mission aborted at waypoint %d: %v\n", i, err)
+				return
+			}
+			<-yawDone
+
+			if tello.missionCancelled() {
+				log.Println("Mission cancelled")
+				return
+			}
+
+			if wp.HoldMs > 0 {
+				time.Sleep(time.Duration(wp.HoldMs) * time.Millisecond)
+			}
+
+			if m.Progress != nil {
+				m.Progress <- MissionProgress{Index: i, Waypoint: wp}
+			}
+		}
+	}()
+
+	return done, nil
+}
+
+// missionCancelled reports whether CancelMission has been called since the
+// running mission started.
+func (tello *Tello) missionCancelled() bool {
+	tello.missionMu.RLock()
+	defer tello.missionMu.RUnlock()
+	return !tello.missionRunning
+}
+
+// CancelMission stops any in-flight RunMission, cancelling whichever
+// autopilot primitive is currently driving it. Because RunMission checks
+// missionRunning between every leg of a waypoint (not just at the top of
+// the loop), the mission Goroutine will bail out as soon as the leg it is
+// currently blocked on completes, rather than running the rest of the
+// waypoint to completion first.
+func (tello *Tello) CancelMission() {
+	tello.missionMu.Lock()
+	tello.missionRunning = false
+	tello.missionMu.Unlock()
+	tello.CancelAutoFlyToXY()
+	tello.CancelAutoFlyToHeight()
+	tello.CancelAutoTurn()
+}
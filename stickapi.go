@@ -0,0 +1,40 @@
+// stickapi.go
+
+// This file exposes a minimal public entry point for driving the virtual
+// control sticks directly, for callers (such as tello/input) that sit
+// outside the tello package and so cannot reach the unexported ctrl* fields.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+// SetSticks sets all four virtual stick axes and immediately sends a stick
+// update frame. Values are in the same raw range (roughly -32768..32767)
+// as the ctrlLx/Ly/Rx/Ry fields they replace; callers are responsible for
+// their own clamping and rate limiting.
+func (tello *Tello) SetSticks(lx, ly, rx, ry int16) {
+	tello.ctrlMu.Lock()
+	tello.ctrlLx = lx
+	tello.ctrlLy = ly
+	tello.ctrlRx = rx
+	tello.ctrlRy = ry
+	tello.ctrlMu.Unlock()
+	tello.sendStickUpdate()
+}